@@ -0,0 +1,83 @@
+// Package codec implements the nibble and hex-prefix (HP) encodings used by the
+// trie package to lay out keys and paths the way Ethereum/Substrate-style Merkle
+// Patricia tries do: 4-bit nibbles instead of byte-addressed paths, and a compact
+// packed form for persisting a nibble path on disk.
+package codec
+
+import "github.com/pkg/errors"
+
+// ErrOddNibbles is returned when a nibble slice with an odd length is passed to a
+// function that requires pairing nibbles back into whole bytes
+var ErrOddNibbles = errors.New("nibble slice has odd length")
+
+// KeyToNibbles expands key into a slice twice as long, one nibble (0-15) per
+// element, high nibble of each byte first
+func KeyToNibbles(key []byte) []byte {
+	nibbles := make([]byte, len(key)*2)
+	for i, b := range key {
+		nibbles[i*2] = b >> 4
+		nibbles[i*2+1] = b & 0x0f
+	}
+	return nibbles
+}
+
+// NibblesToKey packs a nibble slice back into bytes, two nibbles per byte. len(nibbles)
+// must be even.
+func NibblesToKey(nibbles []byte) ([]byte, error) {
+	if len(nibbles)%2 != 0 {
+		return nil, ErrOddNibbles
+	}
+	key := make([]byte, len(nibbles)/2)
+	for i := range key {
+		key[i] = nibbles[i*2]<<4 | nibbles[i*2+1]
+	}
+	return key, nil
+}
+
+// EncodeHP packs nibbles two-per-byte using Ethereum's hex-prefix scheme: the
+// first nibble of the result folds in both ext (leaf vs extension) and the parity
+// of len(nibbles), so the packed form round-trips through DecodeHP without an
+// external length
+func EncodeHP(nibbles []byte, ext byte) []byte {
+	flag := byte(0)
+	if ext != 0 {
+		flag |= 0x2
+	}
+	odd := len(nibbles)%2 == 1
+	if odd {
+		flag |= 0x1
+	}
+
+	hp := make([]byte, 0, len(nibbles)/2+1)
+	if odd {
+		hp = append(hp, flag<<4|nibbles[0])
+		nibbles = nibbles[1:]
+	} else {
+		hp = append(hp, flag<<4)
+	}
+	for i := 0; i < len(nibbles); i += 2 {
+		hp = append(hp, nibbles[i]<<4|nibbles[i+1])
+	}
+	return hp
+}
+
+// DecodeHP reverses EncodeHP, returning the original nibbles and the ext flag
+// folded into hp's leading nibble
+func DecodeHP(hp []byte) (nibbles []byte, ext byte, err error) {
+	if len(hp) == 0 {
+		return nil, 0, errors.New("hp-encoded path cannot be empty")
+	}
+	flag := hp[0] >> 4
+	if flag&0x2 != 0 {
+		ext = 1
+	}
+	odd := flag&0x1 != 0
+
+	if odd {
+		nibbles = append(nibbles, hp[0]&0x0f)
+	}
+	for _, b := range hp[1:] {
+		nibbles = append(nibbles, b>>4, b&0x0f)
+	}
+	return nibbles, ext, nil
+}
@@ -0,0 +1,80 @@
+package codec
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestKeyNibblesRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 32; n++ {
+		key := make([]byte, n)
+		rng.Read(key)
+		nibbles := KeyToNibbles(key)
+		if len(nibbles) != 2*n {
+			t.Fatalf("KeyToNibbles(%x) produced %d nibbles, want %d", key, len(nibbles), 2*n)
+		}
+		for _, nb := range nibbles {
+			if nb > 0x0f {
+				t.Fatalf("KeyToNibbles(%x) produced out-of-range nibble %x", key, nb)
+			}
+		}
+		back, err := NibblesToKey(nibbles)
+		if err != nil {
+			t.Fatalf("NibblesToKey: %v", err)
+		}
+		if !bytes.Equal(back, key) {
+			t.Fatalf("round trip mismatch: key = %x, got back %x", key, back)
+		}
+	}
+}
+
+func TestNibblesToKeyOddLength(t *testing.T) {
+	if _, err := NibblesToKey([]byte{1, 2, 3}); err != ErrOddNibbles {
+		t.Fatalf("NibblesToKey with odd length = %v, want ErrOddNibbles", err)
+	}
+}
+
+// TestHPRoundTrip exhaustively covers every (ext, parity) combination alongside
+// randomized nibble content, since EncodeHP/DecodeHP fold both flags into the
+// leading nibble and an off-by-one there would corrupt every path on disk.
+func TestHPRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, ext := range []byte{0, 1} {
+		for n := 0; n < 32; n++ {
+			nibbles := make([]byte, n)
+			for i := range nibbles {
+				nibbles[i] = byte(rng.Intn(16))
+			}
+			hp := EncodeHP(nibbles, ext)
+
+			gotNibbles, gotExt, err := DecodeHP(hp)
+			if err != nil {
+				t.Fatalf("DecodeHP(EncodeHP(%x, %d)): %v", nibbles, ext, err)
+			}
+			wantExt := byte(0)
+			if ext != 0 {
+				wantExt = 1
+			}
+			if gotExt != wantExt {
+				t.Fatalf("ext round trip mismatch: sent %d, got %d", ext, gotExt)
+			}
+			if len(nibbles) == 0 {
+				if len(gotNibbles) != 0 {
+					t.Fatalf("nibbles round trip mismatch: sent %x, got %x", nibbles, gotNibbles)
+				}
+				continue
+			}
+			if !bytes.Equal(gotNibbles, nibbles) {
+				t.Fatalf("nibbles round trip mismatch: sent %x, got %x", nibbles, gotNibbles)
+			}
+		}
+	}
+}
+
+func TestDecodeHPEmpty(t *testing.T) {
+	if _, _, err := DecodeHP(nil); err == nil {
+		t.Fatal("DecodeHP(nil) should have errored")
+	}
+}
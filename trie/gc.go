@@ -0,0 +1,194 @@
+package trie
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+
+	"github.com/iotexproject/iotex-core/common"
+)
+
+// TrieMode controls how aggressively a Trie keeps old nodes around in the KV backend
+type TrieMode int
+
+const (
+	// Full keeps every node ever written, for every historical root, forever
+	Full TrieMode = iota
+	// Latest only cares about the current root; nothing is refcounted or pruned
+	Latest
+	// GC refcounts every node so historical roots can later be dropped with RemoveRoot
+	GC
+)
+
+// refCountPrefix namespaces the refcount entries that GC mode keeps alongside the
+// node blobs themselves, so a refcount lookup never collides with a hash lookup
+var refCountPrefix = []byte("refcnt-")
+
+// refKey returns the KV key under which hash's refcount is stored
+func refKey(hash common.Hash32B) []byte {
+	return append(append([]byte{}, refCountPrefix...), hash[:]...)
+}
+
+// refCount returns the current refcount of hash, or 0 if it has never been
+// referenced. A real error from dao.Get is propagated rather than coerced into
+// "unreferenced": incRef treats a 0 count as license to re-Put the node and reset
+// its count to 1, so silently swallowing a transient read failure here can stomp a
+// live node's real refcount and make RemoveRoot delete data a live root still needs.
+func (t *Trie) refCount(hash common.Hash32B) (uint32, error) {
+	raw, err := t.dao.Get(refKey(hash))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to load refcount for node %x", hash)
+	}
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint32(raw), nil
+}
+
+// incRef bumps hash's refcount by one, persisting the node blob the first time it is
+// referenced
+func (t *Trie) incRef(hash common.Hash32B, blob []byte) error {
+	cnt, err := t.refCount(hash)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		if err := t.dao.Put(hash[:], blob); err != nil {
+			return errors.Wrapf(err, "failed to persist node %x", hash)
+		}
+	}
+	return t.putRefCount(hash, cnt+1)
+}
+
+// decRef drops hash's refcount by one, deleting the node blob once the count reaches 0
+func (t *Trie) decRef(hash common.Hash32B) error {
+	cnt, err := t.refCount(hash)
+	if err != nil || cnt == 0 {
+		return err
+	}
+	if cnt == 1 {
+		if err := t.dao.Delete(refKey(hash)); err != nil {
+			return errors.Wrapf(err, "failed to delete refcount for node %x", hash)
+		}
+		return errors.Wrapf(t.dao.Delete(hash[:]), "failed to delete node %x", hash)
+	}
+	return t.putRefCount(hash, cnt-1)
+}
+
+func (t *Trie) putRefCount(hash common.Hash32B, cnt uint32) error {
+	raw := make([]byte, 4)
+	binary.BigEndian.PutUint32(raw, cnt)
+	return errors.Wrapf(t.dao.Put(refKey(hash), raw), "failed to persist refcount for node %x", hash)
+}
+
+// persistNode serializes node, stores it under its own hash, and in GC mode bumps its
+// refcount. This is the hook insert()/collapse() callers use whenever a subtree is
+// attached so the new node survives a later RemoveRoot of some unrelated old root.
+func (t *Trie) persistNode(node patricia) (common.Hash32B, error) {
+	blob, err := node.serialize()
+	if err != nil {
+		return common.Hash32B{}, err
+	}
+	hash := node.hash()
+	if t.mode != GC {
+		return hash, errors.Wrapf(t.dao.Put(hash[:], blob), "failed to persist node %x", hash)
+	}
+	return hash, t.incRef(hash, blob)
+}
+
+// Flush persists root as a checkpoint that RemoveRoot can later be called on. In Full
+// and Latest mode this is a no-op: Full never prunes, and Latest does not track
+// individual roots at all.
+func (t *Trie) Flush(root common.Hash32B) error {
+	if t.mode != GC {
+		return nil
+	}
+	return t.dao.Put(rootMarkerKey(root), []byte{1})
+}
+
+// RemoveRoot drops a historical root that was previously Flush-ed: it walks every node
+// reachable from root, decrementing each one's refcount, and deletes any node whose
+// count reaches zero. Nodes still shared with a live root survive because their
+// refcount stays above zero.
+func (t *Trie) RemoveRoot(root common.Hash32B) error {
+	if t.mode != GC {
+		return errors.Wrap(ErrInvalidPatricia, "RemoveRoot is only meaningful in GC mode")
+	}
+	if err := t.walkAndDecRef(root); err != nil {
+		return err
+	}
+	return t.dao.Delete(rootMarkerKey(root))
+}
+
+// walkAndDecRef decrements the refcount of hash and, if that was the last reference,
+// recurses into its children before the blob disappears
+func (t *Trie) walkAndDecRef(hash common.Hash32B) error {
+	cnt, err := t.refCount(hash)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return nil
+	}
+
+	var children [][]byte
+	if cnt == 1 {
+		blob, err := t.dao.Get(hash[:])
+		if err != nil {
+			return errors.Wrapf(err, "failed to load node %x", hash)
+		}
+		node, err := deserializeNode(blob)
+		if err != nil {
+			return err
+		}
+		children = childHashes(node)
+	}
+
+	if err := t.decRef(hash); err != nil {
+		return err
+	}
+	for _, child := range children {
+		var childHash common.Hash32B
+		copy(childHash[:], child)
+		if err := t.walkAndDecRef(childHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// childHashes returns the hashes node points at, for the purpose of GC traversal
+func childHashes(node patricia) [][]byte {
+	switch n := node.(type) {
+	case *branch:
+		children := make([][]byte, 0, RADIX)
+		for _, p := range n.Path {
+			if len(p) > 0 {
+				children = append(children, p)
+			}
+		}
+		return children
+	case *leaf:
+		if n.Ext != 0 {
+			return [][]byte{n.Value}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Copy opens root, a historical root of the same backing store, as a new Trie with
+// the same mode. The returned Trie is not write-protected: an Upsert on it writes
+// new nodes into the shared backing store.
+func (t *Trie) Copy(root common.Hash32B) *Trie {
+	return NewTrie(t.dao, root, t.mode)
+}
+
+// rootMarkerPrefix namespaces the "this root was Flush-ed and can be RemoveRoot-ed"
+// markers kept in GC mode
+var rootMarkerPrefix = []byte("trieroot-")
+
+func rootMarkerKey(root common.Hash32B) []byte {
+	return append(append([]byte{}, rootMarkerPrefix...), root[:]...)
+}
@@ -0,0 +1,138 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/iotexproject/iotex-core/common"
+)
+
+// errKVStore wraps a memKVStore and fails every Get for one specific key, to
+// exercise refCount's error path.
+type errKVStore struct {
+	*memKVStore
+	failKey []byte
+}
+
+func (e *errKVStore) Get(key []byte) ([]byte, error) {
+	if string(key) == string(e.failKey) {
+		return nil, errors.New("injected read failure")
+	}
+	return e.memKVStore.Get(key)
+}
+
+func TestRefCountPropagatesError(t *testing.T) {
+	var hash common.Hash32B
+	copy(hash[:], []byte("some-node-hash"))
+	dao := &errKVStore{memKVStore: newMemKVStore(), failKey: refKey(hash)}
+	tr := NewTrie(dao, common.Hash32B{}, GC)
+
+	if _, err := tr.refCount(hash); err == nil {
+		t.Fatal("refCount should have propagated the injected dao.Get error")
+	}
+}
+
+func TestIncRefDecRefLifecycle(t *testing.T) {
+	dao := newMemKVStore()
+	tr := NewTrie(dao, common.Hash32B{}, GC)
+
+	var hash common.Hash32B
+	copy(hash[:], []byte("node-hash"))
+	blob := []byte("node-blob")
+
+	if err := tr.incRef(hash, blob); err != nil {
+		t.Fatalf("incRef: %v", err)
+	}
+	cnt, err := tr.refCount(hash)
+	if err != nil || cnt != 1 {
+		t.Fatalf("refCount after first incRef = %d, %v, want 1, nil", cnt, err)
+	}
+	if got, err := dao.Get(hash[:]); err != nil || string(got) != string(blob) {
+		t.Fatalf("dao.Get(hash) = %q, %v, want %q, nil", got, err, blob)
+	}
+
+	if err := tr.incRef(hash, blob); err != nil {
+		t.Fatalf("incRef: %v", err)
+	}
+	if cnt, err := tr.refCount(hash); err != nil || cnt != 2 {
+		t.Fatalf("refCount after second incRef = %d, %v, want 2, nil", cnt, err)
+	}
+
+	if err := tr.decRef(hash); err != nil {
+		t.Fatalf("decRef: %v", err)
+	}
+	if cnt, err := tr.refCount(hash); err != nil || cnt != 1 {
+		t.Fatalf("refCount after first decRef = %d, %v, want 1, nil", cnt, err)
+	}
+	if _, err := dao.Get(hash[:]); err != nil {
+		t.Fatalf("node should still be present with refcount 1: %v", err)
+	}
+
+	if err := tr.decRef(hash); err != nil {
+		t.Fatalf("decRef: %v", err)
+	}
+	if cnt, err := tr.refCount(hash); err != nil || cnt != 0 {
+		t.Fatalf("refCount after second decRef = %d, %v, want 0, nil", cnt, err)
+	}
+	if got, err := dao.Get(hash[:]); err != nil || len(got) != 0 {
+		t.Fatal("node should have been deleted once its refcount hit 0")
+	}
+}
+
+func TestFlushNoopAndRemoveRootErrorsOutsideGC(t *testing.T) {
+	tr := NewTrie(newMemKVStore(), common.Hash32B{}, Latest)
+	var root common.Hash32B
+	copy(root[:], []byte("some-root"))
+
+	if err := tr.Flush(root); err != nil {
+		t.Fatalf("Flush outside GC mode should be a no-op, got %v", err)
+	}
+	if err := tr.RemoveRoot(root); err == nil {
+		t.Fatal("RemoveRoot outside GC mode should error")
+	}
+}
+
+// TestUpsertGCRefcounting drives two real Upsert calls in GC mode and checks
+// that the node persistNode/commitInsert touch actually gets its refcount
+// wired up: the lone leaf that held the first key is superseded once a second,
+// disjoint key is inserted, so its refcount should drop to 0 and it should be
+// gone from the store, while the new root should be persisted with a refcount
+// of exactly 1.
+func TestUpsertGCRefcounting(t *testing.T) {
+	dao := newMemKVStore()
+	tr := NewTrie(dao, common.Hash32B{}, GC)
+
+	if err := tr.Upsert([]byte{0x12}, []byte{1}); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	firstRoot := tr.RootHash()
+	if cnt, err := tr.refCount(firstRoot); err != nil || cnt != 1 {
+		t.Fatalf("refCount(firstRoot) = %d, %v, want 1, nil", cnt, err)
+	}
+
+	if err := tr.Upsert([]byte{0xff}, []byte{2}); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	secondRoot := tr.RootHash()
+	if secondRoot == firstRoot {
+		t.Fatal("root hash should change once a second key is inserted")
+	}
+
+	// the old, now-superseded root leaf should have been decref'd to 0 and
+	// removed from the store
+	if cnt, err := tr.refCount(firstRoot); err != nil || cnt != 0 {
+		t.Fatalf("refCount(firstRoot) after supersession = %d, %v, want 0, nil", cnt, err)
+	}
+	if got, err := dao.Get(firstRoot[:]); err != nil || len(got) != 0 {
+		t.Fatal("superseded node should have been deleted")
+	}
+
+	// the new root should be persisted with a live refcount
+	if cnt, err := tr.refCount(secondRoot); err != nil || cnt != 1 {
+		t.Fatalf("refCount(secondRoot) = %d, %v, want 1, nil", cnt, err)
+	}
+	if _, err := dao.Get(secondRoot[:]); err != nil {
+		t.Fatalf("current root node should be retrievable: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package trie
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/iotexproject/iotex-core/db"
+)
+
+// ErrLegacyRadixMismatch is returned when a node blob decodes successfully as a
+// legacy gob branch, but was written before this package switched from
+// byte-addressed to nibble-addressed paths (RADIX 256 down to 16). Such a blob is
+// not corrupt, but UpgradeNodeEncoding cannot turn it into the current branch
+// shape by re-serializing alone: that needs a real key-space restructuring, which
+// is a separate migration this function does not attempt.
+var ErrLegacyRadixMismatch = errors.New("legacy node predates the byte-to-nibble path migration and cannot be upgraded in place")
+
+// UpgradeNodeEncoding runs the one-shot migration from the old gob-based node wire
+// format to the compact encoding. For every hash in keys, it reads the existing blob,
+// decodes it with the legacy gob format, and re-persists it under the same hash using
+// the current serialize(). It is safe to call on a blob that has already been
+// upgraded: such blobs fail the legacy decode and are skipped.
+//
+// A real, pre-existing DB will have legacy branch blobs (RADIX 256) that cannot be
+// upgraded in place at all - see ErrLegacyRadixMismatch - and those are the norm, not
+// the exception, so hitting one does not stop the migration: the key is collected
+// into radixMismatched and UpgradeNodeEncoding continues on to the rest of keys,
+// leaving the caller to decide what to do about the ones it could not handle.
+func UpgradeNodeEncoding(dao db.KVStore, keys [][]byte) (radixMismatched [][]byte, err error) {
+	for _, key := range keys {
+		blob, err := dao.Get(key)
+		if err != nil {
+			return radixMismatched, errors.Wrapf(err, "failed to load node %x", key)
+		}
+		if len(blob) == 0 {
+			continue
+		}
+
+		node, err := deserializeLegacyNode(blob)
+		if err == ErrLegacyRadixMismatch {
+			radixMismatched = append(radixMismatched, key)
+			continue
+		}
+		if err != nil {
+			// already in the compact format (or genuinely corrupt, in which case the
+			// subsequent read path will surface the error)
+			continue
+		}
+		upgraded, err := node.serialize()
+		if err != nil {
+			return radixMismatched, errors.Wrapf(err, "failed to re-serialize node %x", key)
+		}
+		if err := dao.Put(key, upgraded); err != nil {
+			return radixMismatched, errors.Wrapf(err, "failed to persist upgraded node %x", key)
+		}
+	}
+	return radixMismatched, nil
+}
+
+// deserializeLegacyNode decodes a node blob written with the old gob-based format,
+// dispatching on the same leading type tag used by serialize()
+func deserializeLegacyNode(blob []byte) (patricia, error) {
+	if len(blob) == 0 {
+		return nil, errors.Wrap(ErrInvalidPatricia, "empty node blob")
+	}
+	switch blob[0] {
+	case 2:
+		if _, err := deserializeLegacyBranch(blob); err != nil {
+			return nil, err
+		}
+		// the blob decodes fine, but it is byte-addressed (RADIX 256); the live
+		// trie is nibble-addressed (RADIX 16), so there is no branch node we can
+		// hand back here without restructuring the whole subtree
+		return nil, ErrLegacyRadixMismatch
+	case 0, 1:
+		l := &leaf{}
+		if err := l.deserializeLegacyLeaf(blob); err != nil {
+			return nil, err
+		}
+		return l, nil
+	default:
+		return nil, errors.Wrapf(ErrInvalidPatricia, "unknown node type tag %d", blob[0])
+	}
+}
@@ -0,0 +1,103 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+// gobLegacyBranchBlob builds the raw blob a pre-nibble-migration DB would have
+// stored for lb: type tag 2, then lb gob-encoded.
+func gobLegacyBranchBlob(t *testing.T, lb *legacyBranch256) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(lb); err != nil {
+		t.Fatalf("gob-encode legacy branch: %v", err)
+	}
+	return append([]byte{2}, buf.Bytes()...)
+}
+
+// gobLegacyLeafBlob builds the raw blob a pre-compact-encoding DB would have
+// stored for l: type tag l.Ext, then l gob-encoded.
+func gobLegacyLeafBlob(t *testing.T, l *leaf) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(l); err != nil {
+		t.Fatalf("gob-encode legacy leaf: %v", err)
+	}
+	return append([]byte{l.Ext}, buf.Bytes()...)
+}
+
+// TestUpgradeNodeEncodingMixedDB exercises the one-shot migration against a DB
+// that has all three kinds of blob a real database would: already-compact,
+// legacy-but-upgradable, and legacy-byte-addressed (which cannot be upgraded in
+// place). The legacy branch sits in the middle of the key list, so this also
+// covers that UpgradeNodeEncoding keeps going past it instead of aborting.
+func TestUpgradeNodeEncodingMixedDB(t *testing.T) {
+	dao := newMemKVStore()
+
+	compact := &leaf{Ext: 0, Path: ptrcKey{1, 2}, Value: []byte("v-compact")}
+	compactBlob, err := compact.serialize()
+	if err != nil {
+		t.Fatalf("serialize compact leaf: %v", err)
+	}
+	compactKey := []byte("compact-leaf")
+	if err := dao.Put(compactKey, compactBlob); err != nil {
+		t.Fatal(err)
+	}
+
+	legacyLeaf := &leaf{Ext: 0, Path: ptrcKey{3, 4}, Value: []byte("v-legacy-leaf")}
+	legacyLeafKey := []byte("legacy-leaf")
+	if err := dao.Put(legacyLeafKey, gobLegacyLeafBlob(t, legacyLeaf)); err != nil {
+		t.Fatal(err)
+	}
+
+	legacyBranch := &legacyBranch256{}
+	legacyBranch.Path[200] = ptrcKey(bytes.Repeat([]byte{0xaa}, 32))
+	legacyBranchKey := []byte("legacy-branch")
+	if err := dao.Put(legacyBranchKey, gobLegacyBranchBlob(t, legacyBranch)); err != nil {
+		t.Fatal(err)
+	}
+
+	// comes after the radix-mismatched branch in the key list: the migration must
+	// reach it rather than stopping at legacyBranchKey
+	legacyLeaf2 := &leaf{Ext: 0, Path: ptrcKey{5, 6}, Value: []byte("v-legacy-leaf-2")}
+	legacyLeaf2Key := []byte("legacy-leaf-2")
+	if err := dao.Put(legacyLeaf2Key, gobLegacyLeafBlob(t, legacyLeaf2)); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := [][]byte{compactKey, legacyLeafKey, legacyBranchKey, legacyLeaf2Key}
+	mismatched, err := UpgradeNodeEncoding(dao, keys)
+	if err != nil {
+		t.Fatalf("UpgradeNodeEncoding: %v", err)
+	}
+	if len(mismatched) != 1 || string(mismatched[0]) != string(legacyBranchKey) {
+		t.Fatalf("radix-mismatched keys = %q, want [%q]", mismatched, legacyBranchKey)
+	}
+
+	if got, err := dao.Get(compactKey); err != nil || !bytes.Equal(got, compactBlob) {
+		t.Fatalf("compact leaf blob changed: got %x, %v, want %x, nil", got, err, compactBlob)
+	}
+
+	wantLegacyLeaf, err := legacyLeaf.serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dao.Get(legacyLeafKey); err != nil || !bytes.Equal(got, wantLegacyLeaf) {
+		t.Fatalf("legacy leaf not upgraded: got %x, %v, want %x, nil", got, err, wantLegacyLeaf)
+	}
+
+	wantLegacyBranch := gobLegacyBranchBlob(t, legacyBranch)
+	if got, err := dao.Get(legacyBranchKey); err != nil || !bytes.Equal(got, wantLegacyBranch) {
+		t.Fatalf("radix-mismatched branch blob should be untouched: got %x, %v, want %x, nil", got, err, wantLegacyBranch)
+	}
+
+	wantLegacyLeaf2, err := legacyLeaf2.serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dao.Get(legacyLeaf2Key); err != nil || !bytes.Equal(got, wantLegacyLeaf2) {
+		t.Fatalf("legacy leaf 2 not upgraded: got %x, %v, want %x, nil", got, err, wantLegacyLeaf2)
+	}
+}
@@ -0,0 +1,191 @@
+package trie
+
+import (
+	"bytes"
+	"container/list"
+
+	"github.com/pkg/errors"
+
+	"github.com/iotexproject/iotex-core/common"
+	"github.com/iotexproject/iotex-core/trie/codec"
+)
+
+// ancestorStep records the nibble a node was reached through while descending to
+// the insertion point, so the path back to the root can be rebuilt once the
+// insertion point changes
+type ancestorStep struct {
+	hash  common.Hash32B
+	index byte
+}
+
+// Upsert inserts <key, value> into the trie, or replaces the value if key is
+// already present, descending from the root to the node
+// that needs to change, calling its insert() there, and threading the resulting new
+// hash back up to the root. This is the method insert()/collapse() were written
+// for: every node that gets durably written along the way goes through
+// persistNode, so GC mode's refcounting actually reflects real use instead of
+// sitting dead.
+func (t *Trie) Upsert(key, value []byte) error {
+	nibbles := codec.KeyToNibbles(key)
+	if len(nibbles) == 0 {
+		return errors.Wrap(ErrInvalidPatricia, "key cannot be empty")
+	}
+	if t.root == (common.Hash32B{}) {
+		l := &leaf{Path: append(ptrcKey{}, nibbles...), Value: value}
+		hash, err := t.persistNode(l)
+		if err != nil {
+			return err
+		}
+		t.root = hash
+		return nil
+	}
+
+	var path []ancestorStep
+	hash, rem := t.root, nibbles
+	for {
+		blob, err := t.dao.Get(hash[:])
+		if err != nil {
+			return errors.Wrapf(err, "failed to load node %x", hash)
+		}
+		node, err := deserializeNode(blob)
+		if err != nil {
+			return err
+		}
+
+		switch n := node.(type) {
+		case *branch:
+			if len(n.Path[rem[0]]) == 0 {
+				stack := list.New()
+				if err := n.insert(rem, value, stack); err != nil {
+					return err
+				}
+				return t.commitInsert(n, hash, path, stack)
+			}
+			var next common.Hash32B
+			copy(next[:], n.Path[rem[0]])
+			path = append(path, ancestorStep{hash: hash, index: rem[0]})
+			hash, rem = next, rem[1:]
+
+		case *leaf:
+			if n.Ext == 0 {
+				stack := list.New()
+				if bytes.Equal(n.Path, rem) {
+					// key is already present: replace the leaf with one holding value
+					l := getLeaf()
+					l.Path, l.Value = append(ptrcKey{}, rem...), value
+					stack.PushBack(l)
+					return t.commitInsert(n, hash, path, stack)
+				}
+				if err := n.insert(rem, value, stack); err != nil {
+					return err
+				}
+				return t.commitInsert(n, hash, path, stack)
+			}
+			// extension: the path it covers is fixed, not a choice, so normally
+			// the only thing to do is follow it into its single child. But if
+			// rem diverges partway through that fixed path, there is no child to
+			// follow into any more: split the extension into a branch at the
+			// divergence point, same as insert() does for a terminal leaf.
+			_, match, err := n.descend(rem)
+			if err != nil {
+				stack := list.New()
+				if err := n.insert(rem, value, stack); err != nil {
+					return err
+				}
+				return t.commitInsert(n, hash, path, stack)
+			}
+			var next common.Hash32B
+			copy(next[:], n.Value)
+			path = append(path, ancestorStep{hash: hash, index: rem[0]})
+			hash, rem = next, rem[match:]
+
+		default:
+			return errors.Wrap(ErrInvalidPatricia, "unknown node type while descending")
+		}
+	}
+}
+
+// commitInsert persists the nodes an insert() call produced, patches the chain of
+// ancestors leading back to the root to point at the new hash, and updates t.root.
+// In GC mode, every hash that stops being referenced along the way is decref'd.
+func (t *Trie) commitInsert(old patricia, oldHash common.Hash32B, path []ancestorStep, stack *list.List) error {
+	var newHash common.Hash32B
+	switch old.(type) {
+	case *branch:
+		// branch.insert() mutates old in place and only pushes the new child leaf
+		child, ok := stack.Front().Value.(patricia)
+		if !ok {
+			return errors.Wrap(ErrInvalidPatricia, "insert did not push a child node")
+		}
+		if _, err := t.persistNode(child); err != nil {
+			return err
+		}
+		releaseNode(child)
+		h, err := t.persistNode(old)
+		if err != nil {
+			return err
+		}
+		newHash = h
+		releaseNode(old)
+		if t.mode == GC && oldHash != newHash {
+			if err := t.decRef(oldHash); err != nil {
+				return err
+			}
+		}
+	default:
+		// leaf.insert() replaces old wholesale with the chain pushed onto stack;
+		// the first node pushed is the new top of that chain
+		first := true
+		for e := stack.Front(); e != nil; e = e.Next() {
+			node, ok := e.Value.(patricia)
+			if !ok {
+				return errors.Wrap(ErrInvalidPatricia, "insert pushed a non-node value")
+			}
+			h, err := t.persistNode(node)
+			if err != nil {
+				return err
+			}
+			if first {
+				newHash, first = h, false
+			}
+			releaseNode(node)
+		}
+		releaseNode(old)
+		if t.mode == GC {
+			if err := t.decRef(oldHash); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		s := path[i]
+		blob, err := t.dao.Get(s.hash[:])
+		if err != nil {
+			return errors.Wrapf(err, "failed to load node %x", s.hash)
+		}
+		node, err := deserializeNode(blob)
+		if err != nil {
+			return err
+		}
+		switch n := node.(type) {
+		case *branch:
+			n.Path[s.index] = newHash[:]
+		case *leaf:
+			n.Value = newHash[:]
+		}
+		h, err := t.persistNode(node)
+		if err != nil {
+			return err
+		}
+		releaseNode(node)
+		if t.mode == GC {
+			if err := t.decRef(s.hash); err != nil {
+				return err
+			}
+		}
+		newHash = h
+	}
+	t.root = newHash
+	return nil
+}
@@ -0,0 +1,65 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/iotexproject/iotex-core/common"
+)
+
+// verifyAll checks that every key in keys still proves to its matching value
+// against root.
+func verifyAll(t *testing.T, tr *Trie, keys, values [][]byte) {
+	t.Helper()
+	root := tr.RootHash()
+	for i, key := range keys {
+		proof, err := tr.Prove(key)
+		if err != nil {
+			t.Fatalf("Prove(%x): %v", key, err)
+		}
+		got, err := VerifyProof(root, key, proof)
+		if err != nil {
+			t.Fatalf("VerifyProof(%x): %v", key, err)
+		}
+		if string(got) != string(values[i]) {
+			t.Fatalf("VerifyProof(%x) = %x, want %x", key, got, values[i])
+		}
+	}
+}
+
+// TestUpsertRetrievable builds a small trie with Upsert and checks every key
+// proves back out to the value it was given.
+func TestUpsertRetrievable(t *testing.T) {
+	tr := NewTrie(newMemKVStore(), common.Hash32B{}, Latest)
+	keys := [][]byte{{0x12, 0x34}, {0x12, 0x56}, {0x78, 0x00}, {0xff}}
+	values := [][]byte{{1}, {2}, {3}, {4}}
+	for i, key := range keys {
+		if err := tr.Upsert(key, values[i]); err != nil {
+			t.Fatalf("Upsert(%x): %v", key, err)
+		}
+	}
+	verifyAll(t, tr, keys, values)
+}
+
+// TestUpsertSplitsExtensionMidRun is the maintainer's exact repro: the second
+// insert folds {0x12,0x34} and {0x12,0x56} under a shared extension leaf covering
+// nibbles 1 and 2; the third key, {0x78,0x00}, does not share that extension's path at
+// all, so Upsert has to split the extension right at its first nibble instead of
+// erroring out.
+func TestUpsertSplitsExtensionMidRun(t *testing.T) {
+	tr := NewTrie(newMemKVStore(), common.Hash32B{}, Latest)
+	keys := [][]byte{{0x12, 0x34}, {0x12, 0x56}, {0x78, 0x00}}
+	values := [][]byte{{1}, {2}, {3}}
+	for i, key := range keys {
+		if err := tr.Upsert(key, values[i]); err != nil {
+			t.Fatalf("Upsert(%x): %v", key, err)
+		}
+	}
+	verifyAll(t, tr, keys, values)
+}
+
+func TestUpsertRejectsEmptyKey(t *testing.T) {
+	tr := NewTrie(newMemKVStore(), common.Hash32B{}, Latest)
+	if err := tr.Upsert(nil, []byte{1}); err == nil {
+		t.Fatal("Upsert(nil) should have errored")
+	}
+}
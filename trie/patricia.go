@@ -6,12 +6,15 @@ import (
 	"encoding/gob"
 
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/blake2b"
 
 	"github.com/iotexproject/iotex-core/common"
+	"github.com/iotexproject/iotex-core/trie/codec"
 )
 
-const RADIX = 256
+// RADIX is the branching factor of the trie. Keys are expanded into 4-bit nibbles
+// via codec.KeyToNibbles before they reach descend/insert/collapse, so every
+// branch only needs 16 child slots instead of one per possible byte value.
+const RADIX = 16
 
 var (
 	// ErrInvalidPatricia: invalid operation
@@ -35,12 +38,16 @@ type (
 	}
 	// key of next patricia node
 	ptrcKey []byte
-	// branch is the full node having 256 hashes for next level patricia node + hash of leaf node
+	// branch is the full node having RADIX hashes for next level patricia node, keyed
+	// by nibble, plus Value for a key that terminates exactly at this branch (the
+	// 17th slot in the classic 16+1 Ethereum-style branch layout)
 	branch struct {
 		Path  [RADIX]ptrcKey
 		Value []byte
 	}
-	// leaf is squashed path + actual value (or hash of next patricia node for extension)
+	// leaf is squashed nibble path + actual value (or hash of next patricia node for
+	// extension). Path holds one nibble (0-15) per element in memory; it is only
+	// packed two-per-byte via codec.EncodeHP/DecodeHP on the wire.
 	leaf struct {
 		Ext   byte // this is an extension node
 		Path  ptrcKey
@@ -75,11 +82,14 @@ func (b *branch) insert(key, value []byte, stack *list.List) error {
 	if len(node) > 0 {
 		return errors.Wrapf(ErrInvalidPatricia, "branch already covers path = %d", key[0])
 	}
-	// create a new leaf
-	l := leaf{0, key[1:], value}
+	// create a new leaf. It comes from leafPool; the caller that eventually drains
+	// stack and commits the leaf is responsible for calling putLeaf() once it is
+	// durably persisted.
+	l := getLeaf()
+	l.Ext, l.Path, l.Value = 0, key[1:], value
 	hashl := l.hash()
 	b.Path[key[0]] = hashl[:]
-	stack.PushBack(&l)
+	stack.PushBack(l)
 	return nil
 }
 
@@ -104,9 +114,11 @@ func (b *branch) collapse(index byte, childCollapse bool) ([]byte, []byte, bool)
 			value = b.Path[i]
 		}
 	}
-	// branch can be collapsed if only 1 path remaining
+	// branch can be collapsed if only 1 path remaining. b itself is now replaced by
+	// <key, value> in the parent, so it can go back to the pool.
 	if nb == 1 {
 		b.Path[index] = nil
+		putBranch(b)
 		return key, value, true
 	}
 	return nil, nil, false
@@ -118,49 +130,119 @@ func (b *branch) blob() ([]byte, error) {
 	return nil, errors.Wrap(ErrInvalidPatricia, "branch does not store value")
 }
 
-// hash return the hash of this node
+// hash return the hash of this node. It hashes over the same content the compact
+// encoding carries (the populated Path hashes in slot order, then Value), so the
+// root stays identical regardless of which wire encoding the node was persisted with.
 func (b *branch) hash() common.Hash32B {
-	stream := []byte{}
+	buf := getBuf(RADIX*common.HashSize + len(b.Value))
+	defer putBuf(buf)
 	for i := 0; i < RADIX; i++ {
-		stream = append(stream, b.Path[i]...)
+		*buf = append(*buf, b.Path[i]...)
 	}
-	stream = append(stream, b.Value...)
-	return blake2b.Sum256(stream)
+	*buf = append(*buf, b.Value...)
+
+	h := getHasher()
+	defer putHasher(h)
+	h.Write(*buf)
+	var out common.Hash32B
+	copy(out[:], h.Sum(nil))
+	return out
 }
 
-// serialize to bytes
+// serialize to bytes. The wire format is a compact, RLP-like encoding instead of
+// gob: a bitmap of which of the RADIX slots are populated, followed by the
+// populated hashes back to back, followed by Value. This avoids gob's per-value
+// type metadata, which dwarfs the actual payload for a mostly-empty branch.
 func (b *branch) serialize() ([]byte, error) {
-	var stream bytes.Buffer
-	enc := gob.NewEncoder(&stream)
-	if err := enc.Encode(b); err != nil {
-		return nil, err
-	}
+	bitmapLen := RADIX / 8
+	stream := make([]byte, 1+bitmapLen, 1+bitmapLen+RADIX*common.HashSize+len(b.Value))
 	// first byte denotes the type of patricia: 2-branch, 1-extension, 0-leaf
-	return append([]byte{2}, stream.Bytes()...), nil
+	stream[0] = 2
+	bitmap := stream[1 : 1+bitmapLen]
+	for i := 0; i < RADIX; i++ {
+		if len(b.Path[i]) == 0 {
+			continue
+		}
+		bitmap[i/8] |= 1 << uint(i%8)
+		stream = append(stream, b.Path[i]...)
+	}
+	stream = append(stream, b.Value...)
+	return stream, nil
 }
 
 // deserialize to branch
 func (b *branch) deserialize(stream []byte) error {
 	// reset variable
 	*b = branch{}
-	dec := gob.NewDecoder(bytes.NewBuffer(stream[1:]))
-	if err := dec.Decode(b); err != nil {
-		return err
+	bitmapLen := RADIX / 8
+	if len(stream) < 1+bitmapLen {
+		return errors.Wrap(ErrInvalidPatricia, "branch blob is too short")
+	}
+	bitmap := stream[1 : 1+bitmapLen]
+	pos := 1 + bitmapLen
+	for i := 0; i < RADIX; i++ {
+		if bitmap[i/8]&(1<<uint(i%8)) == 0 {
+			continue
+		}
+		if pos+common.HashSize > len(stream) {
+			return errors.Wrap(ErrInvalidPatricia, "branch blob is truncated")
+		}
+		b.Path[i] = append(ptrcKey{}, stream[pos:pos+common.HashSize]...)
+		pos += common.HashSize
+	}
+	if pos < len(stream) {
+		b.Value = append([]byte{}, stream[pos:]...)
 	}
 	return nil
 }
 
+// legacyBranch256 mirrors the branch shape from before this package switched from
+// byte-addressed to nibble-addressed paths (RADIX 256 down to 16): one slot per
+// possible byte value instead of per nibble. It exists only so
+// deserializeLegacyBranch can gob-decode a genuinely old blob; turning the result
+// into the current, nibble-addressed branch shape needs a real key-space
+// restructuring (each old byte-indexed slot becomes two levels of nibble-indexed
+// branches), which is out of scope here - see deserializeLegacyBranch.
+type legacyBranch256 struct {
+	Path  [256]ptrcKey
+	Value []byte
+}
+
+// deserializeLegacyBranch decodes a branch previously persisted with the old
+// gob-based, byte-addressed wire format. It exists solely for the one-shot DB
+// upgrade to the compact encoding and should not be called on blobs written after
+// the upgrade.
+//
+// It decodes into legacyBranch256, not branch: a blob written before the
+// byte-to-nibble switch has up to 256 populated slots, which does not fit the
+// current branch's 16-slot Path, and reinterpreting it correctly requires
+// rebuilding the subtree rather than re-tagging the struct. A caller that gets a
+// non-nil *legacyBranch256 back has a genuinely old blob on its hands, not a
+// corrupt one; see deserializeLegacyNode's use of ErrLegacyRadixMismatch.
+func deserializeLegacyBranch(stream []byte) (*legacyBranch256, error) {
+	legacy := &legacyBranch256{}
+	dec := gob.NewDecoder(bytes.NewBuffer(stream[1:]))
+	if err := dec.Decode(legacy); err != nil {
+		return nil, err
+	}
+	return legacy, nil
+}
+
 //======================================
 // functions for leaf
 //======================================
 // descend returns the key to retrieve next patricia, and length of matching path in bytes
 func (l *leaf) descend(key []byte) ([]byte, int, error) {
+	// bound the scan by len(l.Path) explicitly instead of relying on a mismatch to
+	// stop it: a split that diverges on an old leaf's very last nibble leaves the
+	// remainder with a zero-length Path, and indexing l.Path[match] once match
+	// reaches that length would run past the end of the slice
 	match := 0
-	for l.Path[match] == key[match] {
+	for match < len(l.Path) && l.Path[match] == key[match] {
 		match++
-		if match == len(l.Path) {
-			return l.Value, match, nil
-		}
+	}
+	if match == len(l.Path) {
+		return l.Value, match, nil
 	}
 	return nil, match, ErrPathDiverge
 }
@@ -180,10 +262,6 @@ func (l *leaf) ascend(key []byte, index byte) bool {
 
 // insert <key, value> at current patricia node
 func (l *leaf) insert(key, value []byte, stack *list.List) error {
-	if l.Ext == 1 {
-		// TODO: insert for extension
-		return nil
-	}
 	// get the matching length
 	match := 0
 	for l.Path[match] == key[match] {
@@ -193,24 +271,42 @@ func (l *leaf) insert(key, value []byte, stack *list.List) error {
 	if match == len(l.Path) {
 		return errors.Wrapf(ErrInvalidPatricia, "try to split a node with matching path = %x", l.Path)
 	}
-	// add 2 leaf, l1 is current node, l2 for new <key, value>
-	l1 := leaf{0, l.Path[match+1:], l.Value}
+	// add 2 leaf, l1 is current node, l2 for new <key, value>. l1 keeps l's own Ext:
+	// for a terminal leaf (Ext 0) that means it still carries l's value; for an
+	// extension (Ext 1) it still points at whatever child l used to point at, just
+	// under the shorter path left after match+1 nibbles are absorbed by the new
+	// branch below; if that leaves an extension with no path at all, l1 is dropped
+	// and the branch points straight at l's child. All nodes below come from their
+	// pools; whoever drains stack and commits them calls putLeaf()/putBranch() once
+	// each is durably persisted.
+	l1 := getLeaf()
+	l1.Ext, l1.Path, l1.Value = l.Ext, l.Path[match+1:], l.Value
 	hashl1 := l1.hash()
-	l2 := leaf{0, key[match+1:], value}
+	l2 := getLeaf()
+	l2.Ext, l2.Path, l2.Value = 0, key[match+1:], value
 	hashl2 := l2.hash()
 	// add 1 branch to link 2 new leaf
-	b := branch{}
+	b := getBranch()
 	b.Path[l.Path[match]] = hashl1[:]
+	emptyExt := l1.Ext == 1 && len(l1.Path) == 0
+	if emptyExt {
+		b.Path[l.Path[match]] = l.Value
+	}
 	b.Path[key[match]] = hashl2[:]
 	// if there's matching part, add 1 ext leading to new branch
 	if match > 0 {
 		hashb := b.hash()
-		e := leaf{1, key[:match], hashb[:]}
-		stack.PushBack(&e)
+		e := getLeaf()
+		e.Ext, e.Path, e.Value = 1, key[:match], hashb[:]
+		stack.PushBack(e)
 	}
-	stack.PushBack(&b)
-	stack.PushBack(&l1)
-	stack.PushBack(&l2)
+	stack.PushBack(b)
+	if emptyExt {
+		putLeaf(l1)
+	} else {
+		stack.PushBack(l1)
+	}
+	stack.PushBack(l2)
 	return nil
 }
 
@@ -233,7 +329,10 @@ func (l *leaf) collapse(index byte, childCollapse bool) ([]byte, []byte, bool) {
 	if !childCollapse {
 		return nil, nil, false
 	}
-	return l.Path, l.Value, true
+	// l is being pulled up into its parent as <key, value>, so it can go back to the pool
+	key, value := l.Path, l.Value
+	putLeaf(l)
+	return key, value, true
 }
 
 // blob return the value stored in the node
@@ -247,25 +346,67 @@ func (l *leaf) blob() ([]byte, error) {
 
 // hash return the hash of this node
 func (l *leaf) hash() common.Hash32B {
-	stream := append([]byte{l.Ext}, l.Path...)
-	stream = append(stream, l.Value...)
-	return blake2b.Sum256(stream)
+	buf := getBuf(1 + len(l.Path) + len(l.Value))
+	defer putBuf(buf)
+	*buf = append(*buf, l.Ext)
+	*buf = append(*buf, l.Path...)
+	*buf = append(*buf, l.Value...)
+
+	h := getHasher()
+	defer putHasher(h)
+	h.Write(*buf)
+	var out common.Hash32B
+	copy(out[:], h.Sum(nil))
+	return out
 }
 
-// serialize to bytes
+// serialize to bytes. The wire format keeps l.Ext as the leading type tag (so
+// callers can still dispatch branch/extension/leaf off the first byte alone),
+// followed by a 1-byte length of the hex-prefix encoded path, the HP-packed path
+// itself (two nibbles per byte, see codec.EncodeHP), and finally Value.
 func (l *leaf) serialize() ([]byte, error) {
-	stream := bytes.Buffer{}
-	enc := gob.NewEncoder(&stream)
-	if err := enc.Encode(l); err != nil {
-		return nil, err
+	hp := codec.EncodeHP(l.Path, l.Ext)
+	if len(hp) > 0xff {
+		return nil, errors.Wrapf(ErrInvalidPatricia, "path length %d does not fit in the leaf header", len(l.Path))
 	}
-	// first byte denotes the type of patricia: 2-branch, 1-extension, 0-leaf
-	return append([]byte{l.Ext}, stream.Bytes()...), nil
+	stream := make([]byte, 0, 2+len(hp)+len(l.Value))
+	stream = append(stream, l.Ext, byte(len(hp)))
+	stream = append(stream, hp...)
+	stream = append(stream, l.Value...)
+	return stream, nil
 }
 
 // deserialize to leaf
 func (l *leaf) deserialize(stream []byte) error {
 	// reset variable
+	*l = leaf{}
+	if len(stream) < 2 {
+		return errors.Wrap(ErrInvalidPatricia, "leaf blob is too short")
+	}
+	l.Ext = stream[0]
+	hpLen := int(stream[1])
+	if 2+hpLen > len(stream) {
+		return errors.Wrap(ErrInvalidPatricia, "leaf blob is truncated")
+	}
+	nibbles, _, err := codec.DecodeHP(stream[2 : 2+hpLen])
+	if err != nil {
+		return errors.Wrap(err, "failed to decode hex-prefix path")
+	}
+	l.Path = nibbles
+	if rest := stream[2+hpLen:]; len(rest) > 0 {
+		l.Value = append([]byte{}, rest...)
+	}
+	return nil
+}
+
+// deserializeLegacyLeaf decodes a leaf previously persisted with the old
+// gob-based wire format. It exists solely for the one-shot DB upgrade to the
+// compact encoding and should not be called on blobs written after the upgrade.
+//
+// Unlike branch, leaf's field layout did not change across the byte-to-nibble
+// switch (Path was, and still is, a plain []byte; only how its contents are
+// interpreted changed), so decoding straight into the current leaf shape is safe.
+func (l *leaf) deserializeLegacyLeaf(stream []byte) error {
 	*l = leaf{}
 	dec := gob.NewDecoder(bytes.NewBuffer(stream[1:]))
 	if err := dec.Decode(l); err != nil {
@@ -0,0 +1,90 @@
+package trie
+
+import (
+	"hash"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/iotexproject/iotex-core/common"
+)
+
+// branchPool and leafPool recycle the *branch/*leaf values insert() allocates on
+// every call, most of which only live until the caller commits them to the KV store.
+var (
+	branchPool = sync.Pool{New: func() interface{} { return new(branch) }}
+	leafPool   = sync.Pool{New: func() interface{} { return new(leaf) }}
+	// bufPool recycles the scratch buffer hash()/serialize() build their stream in
+	bufPool = sync.Pool{New: func() interface{} { buf := make([]byte, 0, RADIX*common.HashSize); return &buf }}
+	// hasherPool recycles a blake2b hash.Hash so hash() does not allocate one per call
+	hasherPool = sync.Pool{New: func() interface{} {
+		h, _ := blake2b.New256(nil)
+		return h
+	}}
+)
+
+// getBranch returns a zeroed *branch from the pool
+func getBranch() *branch {
+	b := branchPool.Get().(*branch)
+	*b = branch{}
+	return b
+}
+
+// putBranch returns b to the pool. The caller must not use b again afterward.
+func putBranch(b *branch) {
+	branchPool.Put(b)
+}
+
+// getLeaf returns a zeroed *leaf from the pool
+func getLeaf() *leaf {
+	l := leafPool.Get().(*leaf)
+	*l = leaf{}
+	return l
+}
+
+// putLeaf returns l to the pool. The caller must not use l again afterward.
+func putLeaf(l *leaf) {
+	leafPool.Put(l)
+}
+
+// getBuf returns a pooled scratch buffer, reset to length 0 with at least size
+// capacity
+func getBuf(size int) *[]byte {
+	buf := bufPool.Get().(*[]byte)
+	if cap(*buf) < size {
+		*buf = make([]byte, 0, size)
+	} else {
+		*buf = (*buf)[:0]
+	}
+	return buf
+}
+
+// putBuf returns buf to the pool
+func putBuf(buf *[]byte) {
+	bufPool.Put(buf)
+}
+
+// getHasher returns a pooled, reset blake2b hasher
+func getHasher() hash.Hash {
+	h := hasherPool.Get().(hash.Hash)
+	h.Reset()
+	return h
+}
+
+// putHasher returns h to the pool
+func putHasher(h hash.Hash) {
+	hasherPool.Put(h)
+}
+
+// releaseNode returns node to branchPool/leafPool. Callers must only do this once
+// node has been durably persisted and is not reachable from anywhere else in the
+// trie still being worked on: persistNode copies node's content into the blob it
+// writes, so the in-memory value is disposable the instant that call returns.
+func releaseNode(node patricia) {
+	switch n := node.(type) {
+	case *branch:
+		putBranch(n)
+	case *leaf:
+		putLeaf(n)
+	}
+}
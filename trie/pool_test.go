@@ -0,0 +1,34 @@
+package trie
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/iotexproject/iotex-core/common"
+)
+
+// BenchmarkTrieUpsert drives real Trie.Upsert calls, which is where nodes taken
+// with getBranch/getLeaf go back to their pools via commitInsert's releaseNode
+// calls, and where every hash() takes and returns a getBuf/getHasher pair; run
+// with -benchmem to see the allocs/op and ns/op the sync.Pool reuse in
+// pool.go actually cuts down on.
+func BenchmarkTrieUpsert(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	keys := make([][]byte, b.N)
+	values := make([][]byte, b.N)
+	for i := range keys {
+		k := make([]byte, 20)
+		rng.Read(k)
+		keys[i] = k
+		values[i] = []byte{byte(i)}
+	}
+
+	tr := NewTrie(newMemKVStore(), common.Hash32B{}, Latest)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tr.Upsert(keys[i], values[i]); err != nil {
+			b.Fatalf("Upsert(%x): %v", keys[i], err)
+		}
+	}
+}
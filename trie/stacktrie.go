@@ -0,0 +1,171 @@
+package trie
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/iotexproject/iotex-core/common"
+	"github.com/iotexproject/iotex-core/trie/codec"
+)
+
+// ErrOutOfOrder is returned when keys are not inserted in strict lexicographic order
+var ErrOutOfOrder = errors.New("keys must be inserted in strict lexicographic order")
+
+// spineEntry is a branch still open for more children, together with the nibble
+// depth at which it makes its routing decision
+type spineEntry struct {
+	depth int
+	node  *branch
+}
+
+// StackTrie computes a Patricia root incrementally over keys that arrive in strict
+// lexicographic order. It mirrors exactly what repeatedly calling leaf.insert() on a
+// full in-memory trie would build (including folding a run of nibbles shared by
+// nothing but a single child into an extension leaf{Ext: 1, ...}), but it only ever
+// keeps the spine of branches from the root down to the path of the most recently
+// inserted key in memory: once a key is inserted, any subtree to the "left" of the
+// insertion path can never change, so it is hashed and folded into its parent right
+// away instead of being kept around. This gives O(depth) memory for computing a root
+// over N keys, versus the O(N) a full trie built with insert()/collapse() requires,
+// at the cost of only supporting append-in-order inserts (no Get/Delete, and no key
+// may be a prefix of another).
+type StackTrie struct {
+	spine        []spineEntry
+	rawPrevKey   []byte // previous key as passed in, for the ordering check
+	prevNibbles  []byte // previous key expanded into nibbles, for indexing the spine
+	pendingValue []byte // value of the not-yet-closed leaf at the end of the spine
+}
+
+// NewStackTrie creates a StackTrie ready for the first TryUpdate call
+func NewStackTrie() *StackTrie {
+	return &StackTrie{}
+}
+
+// TryUpdate inserts <key, value>; key must sort strictly after every key inserted so far
+func (s *StackTrie) TryUpdate(key, value []byte) error {
+	if len(key) == 0 {
+		return errors.Wrap(ErrInvalidPatricia, "key cannot be empty")
+	}
+	if s.prevNibbles == nil {
+		s.pendingValue = value
+		s.prevNibbles = codec.KeyToNibbles(key)
+		s.rawPrevKey = key
+		return nil
+	}
+	if bytes.Compare(key, s.rawPrevKey) <= 0 {
+		return errors.Wrapf(ErrOutOfOrder, "key = %x does not sort after previous key = %x", key, s.rawPrevKey)
+	}
+	nibbles := codec.KeyToNibbles(key)
+	cpl := commonPrefixLen(s.prevNibbles, nibbles)
+
+	openDepth := 0
+	if n := len(s.spine); n > 0 {
+		openDepth = s.spine[n-1].depth + 1
+	}
+
+	if cpl >= openDepth {
+		// the two keys diverge inside the still-open pending leaf: split it into a
+		// new branch exactly the way leaf.insert() would
+		match := cpl - openDepth
+		oldSuffix := s.prevNibbles[openDepth:]
+		if match == len(oldSuffix) {
+			return errors.Wrapf(ErrInvalidPatricia, "key = %x is a prefix extension of previous key = %x", key, s.rawPrevKey)
+		}
+		l1 := leaf{0, append(ptrcKey{}, oldSuffix[match+1:]...), s.pendingValue}
+		h1 := l1.hash()
+		nb := &branch{}
+		nb.Path[oldSuffix[match]] = h1[:]
+		s.spine = append(s.spine, spineEntry{depth: cpl, node: nb})
+	} else {
+		// the divergence happened above the pending leaf: close it as-is, then close
+		// any branch that can no longer receive another child
+		hash, depth := s.closePending()
+		for len(s.spine) > 0 && s.spine[len(s.spine)-1].depth > cpl {
+			top := s.spine[len(s.spine)-1]
+			s.spine = s.spine[:len(s.spine)-1]
+			s.attach(top.node, top.depth, hash, depth)
+			h := top.node.hash()
+			hash, depth = h[:], top.depth
+		}
+
+		var target *branch
+		if n := len(s.spine); n > 0 && s.spine[n-1].depth == cpl {
+			target = s.spine[n-1].node
+		} else {
+			target = &branch{}
+			s.spine = append(s.spine, spineEntry{depth: cpl, node: target})
+		}
+		s.attach(target, cpl, hash, depth)
+	}
+
+	s.prevNibbles = nibbles
+	s.rawPrevKey = key
+	s.pendingValue = value
+	return nil
+}
+
+// Hash finalizes the remaining spine and returns the root hash
+func (s *StackTrie) Hash() common.Hash32B {
+	if s.prevNibbles == nil {
+		return blake2b.Sum256(nil)
+	}
+	hash, depth := s.closePending()
+	for len(s.spine) > 0 {
+		top := s.spine[len(s.spine)-1]
+		s.spine = s.spine[:len(s.spine)-1]
+		s.attach(top.node, top.depth, hash, depth)
+		h := top.node.hash()
+		hash, depth = h[:], top.depth
+	}
+	// every key shares the first `depth` nibbles, so the root itself is an extension
+	// over that shared run unless it happens to be empty
+	if depth > 0 {
+		e := leaf{1, append(ptrcKey{}, s.prevNibbles[:depth]...), hash}
+		return e.hash()
+	}
+	var out common.Hash32B
+	copy(out[:], hash)
+	return out
+}
+
+// closePending hashes the not-yet-closed leaf sitting at the end of the spine (the
+// most recently inserted key's remaining, unsplit nibble suffix) without mutating
+// the spine itself
+func (s *StackTrie) closePending() ([]byte, int) {
+	openDepth := 0
+	if n := len(s.spine); n > 0 {
+		openDepth = s.spine[n-1].depth + 1
+	}
+	l := leaf{0, append(ptrcKey{}, s.prevNibbles[openDepth:]...), s.pendingValue}
+	h := l.hash()
+	return h[:], openDepth
+}
+
+// attach links the closed subtree (hash, at hashDepth) into node's slot for the
+// nibble at nodeDepth, wrapping it in an extension leaf first if node and the
+// subtree are separated by a run of nibbles that belongs to neither
+func (s *StackTrie) attach(node *branch, nodeDepth int, hash []byte, hashDepth int) {
+	run := s.prevNibbles[nodeDepth+1 : hashDepth]
+	slot := hash
+	if len(run) > 0 {
+		e := leaf{1, append(ptrcKey{}, run...), hash}
+		eh := e.hash()
+		slot = eh[:]
+	}
+	node.Path[s.prevNibbles[nodeDepth]] = slot
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
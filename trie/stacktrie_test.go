@@ -0,0 +1,126 @@
+package trie
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/iotexproject/iotex-core/common"
+)
+
+// memKVStore is a minimal in-memory db.KVStore, used only to drive a real Trie in
+// these tests without pulling in whatever backing store the full repo runs
+// against. A missing key reads back as an empty value with no error, which is
+// what refCount and UpgradeNodeEncoding treat as "not stored".
+type memKVStore struct {
+	data map[string][]byte
+}
+
+func newMemKVStore() *memKVStore {
+	return &memKVStore{data: make(map[string][]byte)}
+}
+
+func (m *memKVStore) Get(key []byte) ([]byte, error) {
+	return m.data[string(key)], nil
+}
+
+func (m *memKVStore) Put(key, value []byte) error {
+	m.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (m *memKVStore) Delete(key []byte) error {
+	delete(m.data, string(key))
+	return nil
+}
+
+// fullTrieRoot inserts keys/values, in order, into a real Trie backed by an
+// in-memory store and returns its root hash
+func fullTrieRoot(t *testing.T, keys, values [][]byte) common.Hash32B {
+	tr := NewTrie(newMemKVStore(), common.Hash32B{}, Latest)
+	for i, key := range keys {
+		if err := tr.Upsert(key, values[i]); err != nil {
+			t.Fatalf("full trie Upsert(%x): %v", key, err)
+		}
+	}
+	return tr.RootHash()
+}
+
+// stackTrieRoot inserts keys/values, in order, into a StackTrie and returns its root
+func stackTrieRoot(t *testing.T, keys, values [][]byte) common.Hash32B {
+	st := NewStackTrie()
+	for i, key := range keys {
+		if err := st.TryUpdate(key, values[i]); err != nil {
+			t.Fatalf("StackTrie.TryUpdate(%x): %v", key, err)
+		}
+	}
+	return st.Hash()
+}
+
+// TestStackTrieSharedPrefix is the concrete two-key repro from review: keys that
+// share a nibble must fold into an extension leaf, exactly like leaf.insert() does.
+func TestStackTrieSharedPrefix(t *testing.T) {
+	keys := [][]byte{{0x00}, {0x01}}
+	values := [][]byte{{1}, {2}}
+
+	want := fullTrieRoot(t, keys, values)
+	got := stackTrieRoot(t, keys, values)
+	if got != want {
+		t.Fatalf("StackTrie root = %x, full trie root = %x", got, want)
+	}
+}
+
+// TestStackTrieMatchesFullTrie compares StackTrie's root against a real trie built
+// the normal way, over many rounds of shuffled-then-sorted random keys.
+func TestStackTrieMatchesFullTrie(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	for trial := 0; trial < 30; trial++ {
+		n := 1 + rng.Intn(60)
+		seen := make(map[string]bool, n)
+		keys := make([][]byte, 0, n)
+		values := make([][]byte, 0, n)
+		for len(keys) < n {
+			// fixed-length keys: the trie (and StackTrie) do not support one key
+			// being a strict prefix of another, which equal-length keys can never be
+			k := make([]byte, 2)
+			rng.Read(k)
+			if seen[string(k)] {
+				continue
+			}
+			seen[string(k)] = true
+			keys = append(keys, k)
+			values = append(values, []byte{byte(len(keys))})
+		}
+
+		// shuffle, then sort: StackTrie requires sorted input, the full trie does not
+		rng.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+			values[i], values[j] = values[j], values[i]
+		})
+		sortPairs(keys, values)
+
+		want := fullTrieRoot(t, keys, values)
+		got := stackTrieRoot(t, keys, values)
+		if got != want {
+			t.Fatalf("trial %d: StackTrie root = %x, full trie root = %x, keys = %x", trial, got, want, keys)
+		}
+	}
+}
+
+// sortPairs sorts keys (and values along with them) into ascending order
+func sortPairs(keys, values [][]byte) {
+	sort.Sort(&byKey{keys, values})
+}
+
+type byKey struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+func (b *byKey) Len() int           { return len(b.keys) }
+func (b *byKey) Less(i, j int) bool { return bytes.Compare(b.keys[i], b.keys[j]) < 0 }
+func (b *byKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
@@ -0,0 +1,238 @@
+package trie
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/iotexproject/iotex-core/common"
+	"github.com/iotexproject/iotex-core/db"
+	"github.com/iotexproject/iotex-core/trie/codec"
+)
+
+// Trie is a Patricia trie backed by a key-value store, where every node is persisted
+// under its own hash so it can be shared across historical roots
+type Trie struct {
+	dao  db.KVStore
+	root common.Hash32B
+	mode TrieMode
+}
+
+// NewTrie creates a Trie rooted at root and backed by dao. mode controls whether old
+// nodes are ever reclaimed; see TrieMode.
+func NewTrie(dao db.KVStore, root common.Hash32B, mode TrieMode) *Trie {
+	return &Trie{dao: dao, root: root, mode: mode}
+}
+
+// RootHash returns the current root hash of the trie
+func (t *Trie) RootHash() common.Hash32B {
+	return t.root
+}
+
+// Prove returns the list of serialized nodes, from root down to the leaf, that
+// together prove the value stored at key. The returned blobs are exactly what
+// VerifyProof needs to re-derive the same path without access to the KV store. If
+// key is not in the trie, Prove returns an error; it does not build a proof of
+// absence.
+func (t *Trie) Prove(key []byte) ([][]byte, error) {
+	var proof [][]byte
+	hash := t.root[:]
+	key = codec.KeyToNibbles(key)
+	for {
+		blob, err := t.dao.Get(hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load node %x", hash)
+		}
+		proof = append(proof, blob)
+
+		node, err := deserializeNode(blob)
+		if err != nil {
+			return nil, err
+		}
+		value, match, err := node.descend(key)
+		if err != nil {
+			return nil, err
+		}
+		if l, ok := node.(*leaf); ok && l.Ext == 0 {
+			return proof, nil
+		}
+		key = key[match:]
+		hash = value
+	}
+}
+
+// VerifyProof walks proof, a chain of serialized nodes rooted at root, checking at
+// every step that the child hash referenced by the parent matches the hash of the
+// next blob, and returns the value stored at key if the chain is valid
+func VerifyProof(root common.Hash32B, key []byte, proof [][]byte) ([]byte, error) {
+	hash := root[:]
+	key = codec.KeyToNibbles(key)
+	for i, blob := range proof {
+		h := blake2b.Sum256(blob)
+		if !bytes.Equal(h[:], hash) {
+			return nil, errors.Wrapf(ErrInvalidPatricia, "proof node %d hash mismatch with %x", i, hash)
+		}
+
+		node, err := deserializeNode(blob)
+		if err != nil {
+			return nil, err
+		}
+		value, match, err := node.descend(key)
+		if err != nil {
+			return nil, err
+		}
+		if l, ok := node.(*leaf); ok && l.Ext == 0 {
+			return value, nil
+		}
+		key = key[match:]
+		hash = value
+	}
+	return nil, errors.Wrap(ErrInvalidPatricia, "proof exhausted before reaching a leaf")
+}
+
+// ProveRange returns the serialized blob of every node whose subtree can hold a key
+// in [start, end], which is enough for a light client to verify that [start, end] is
+// a contiguous, unmodified slice of the trie without fetching the whole thing: any
+// key in range must resolve to a leaf among these blobs, and any key outside the
+// range is provably absent because the branch slots that could have led to it are
+// either empty or excluded by the walk's own bounds.
+func (t *Trie) ProveRange(start, end []byte) ([][]byte, error) {
+	if bytes.Compare(start, end) > 0 {
+		return nil, errors.Wrapf(ErrInvalidPatricia, "range start %x is after end %x", start, end)
+	}
+	seen := make(map[string]bool)
+	var proof [][]byte
+	startNibbles, endNibbles := codec.KeyToNibbles(start), codec.KeyToNibbles(end)
+	if err := t.walkRange(t.root[:], startNibbles, true, endNibbles, true, &proof, seen); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// walkRange records the blob at hash, then recurses into every child that can hold
+// a key in [lo, hi]. hasLo/hasHi say whether lo/hi still constrain this subtree at
+// all: once the path taken to get here has left a bound's own nibbles on the
+// in-range side (or, past an extension, has used the bound up), the corresponding
+// hasLo/hasHi becomes false and that side of the subtree is walked in full.
+func (t *Trie) walkRange(hash []byte, lo []byte, hasLo bool, hi []byte, hasHi bool, proof *[][]byte, seen map[string]bool) error {
+	blob, err := t.dao.Get(hash)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load node %x", hash)
+	}
+	h := blake2b.Sum256(blob)
+	if !seen[string(h[:])] {
+		seen[string(h[:])] = true
+		*proof = append(*proof, blob)
+	}
+
+	node, err := deserializeNode(blob)
+	if err != nil {
+		return err
+	}
+	switch n := node.(type) {
+	case *branch:
+		loIdx, hiIdx := byte(0), byte(RADIX-1)
+		if hasLo && len(lo) > 0 {
+			loIdx = lo[0]
+		}
+		if hasHi && len(hi) > 0 {
+			hiIdx = hi[0]
+		}
+		for i := loIdx; i <= hiIdx; i++ {
+			child := n.Path[i]
+			if len(child) == 0 {
+				continue
+			}
+			childHasLo, childLo := false, []byte(nil)
+			if hasLo && len(lo) > 0 && i == lo[0] {
+				childHasLo, childLo = true, lo[1:]
+			}
+			childHasHi, childHi := false, []byte(nil)
+			if hasHi && len(hi) > 0 && i == hi[0] {
+				childHasHi, childHi = true, hi[1:]
+			}
+			if err := t.walkRange(child, childLo, childHasLo, childHi, childHasHi, proof, seen); err != nil {
+				return err
+			}
+			if i == RADIX-1 {
+				break
+			}
+		}
+		return nil
+
+	case *leaf:
+		if n.Ext == 0 {
+			// terminal value leaf: the blob recorded above already proves it
+			return nil
+		}
+		childLo, activeLo, skip := boundPastExtension(n.Path, lo, hasLo, true)
+		if skip {
+			return nil
+		}
+		childHi, activeHi, skip := boundPastExtension(n.Path, hi, hasHi, false)
+		if skip {
+			return nil
+		}
+		return t.walkRange(n.Value, childLo, activeLo, childHi, activeHi, proof, seen)
+
+	default:
+		return errors.Wrap(ErrInvalidPatricia, "unknown node type while walking range")
+	}
+}
+
+// boundPastExtension advances a single range bound (lo if lower is true, else hi)
+// past an extension leaf's fixed path. An extension's path is not a choice the walk
+// makes, so it has to be compared against the bound nibble by nibble instead of
+// indexed into like a branch slot. skip reports that the extension's entire subtree
+// falls outside the bound and should not be walked at all; otherwise newActive says
+// whether the bound still constrains anything below this point, with newBound as
+// its remaining suffix.
+func boundPastExtension(path, bound []byte, active, lower bool) (newBound []byte, newActive, skip bool) {
+	if !active {
+		return nil, false, false
+	}
+	n := len(path)
+	if len(bound) < n {
+		n = len(bound)
+	}
+	for i := 0; i < n; i++ {
+		if path[i] == bound[i] {
+			continue
+		}
+		below := path[i] < bound[i]
+		// lower bound: a path below lo means the subtree is entirely before the
+		// range, skip it; a path above lo means lo is already satisfied.
+		// upper bound: a path above hi means the subtree is entirely past the
+		// range, skip it; a path below hi means hi is already satisfied.
+		return nil, false, below == lower
+	}
+	if len(bound) > len(path) {
+		return bound[len(path):], true, false
+	}
+	return nil, false, false
+}
+
+// deserializeNode reconstructs the branch/leaf/extension node encoded in blob, using
+// the leading type tag written by serialize(): 2-branch, 1-extension, 0-leaf
+func deserializeNode(blob []byte) (patricia, error) {
+	if len(blob) == 0 {
+		return nil, errors.Wrap(ErrInvalidPatricia, "empty node blob")
+	}
+	switch blob[0] {
+	case 2:
+		b := &branch{}
+		if err := b.deserialize(blob); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case 0, 1:
+		l := &leaf{}
+		if err := l.deserialize(blob); err != nil {
+			return nil, err
+		}
+		return l, nil
+	default:
+		return nil, errors.Wrapf(ErrInvalidPatricia, "unknown node type tag %d", blob[0])
+	}
+}